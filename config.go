@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// configDir returns incogniterm's configuration directory, honoring
+// XDG_CONFIG_HOME on Linux (via os.UserConfigDir) and its platform
+// equivalents elsewhere, creating it if it does not already exist.
+func configDir() (string, error) {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(base, "incogniterm")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/eugeniofciuvasile/incogniterm/persona"
+)
+
+// writeIdentityWrappers sets up the full IdentityShim for a session: wrapper
+// scripts in binDir for every command a demo is likely to run to inspect
+// its own identity (id, whoami, hostname, uname, w, who, last, logname,
+// groups, getent, and a cat/head shim), the fake /etc files those shims
+// read from, and a matching ~/.gitconfig and empty ~/.ssh/known_hosts so
+// that tools like git work out of the box under the persona.
+func writeIdentityWrappers(home, binDir string, p *persona.Persona) error {
+	fakeEtc := filepath.Join(home, "fake_etc")
+	if err := os.MkdirAll(fakeEtc, 0o755); err != nil {
+		return fmt.Errorf("create fake /etc: %w", err)
+	}
+	if err := writeFakeEtcFiles(fakeEtc, p); err != nil {
+		return err
+	}
+
+	scripts := map[string]string{
+		"id":       idScript(p),
+		"whoami":   echoScript(p.User),
+		"hostname": echoScript(p.Host),
+		"logname":  echoScript(p.User),
+		"groups":   echoScript(strings.Join(p.Groups, " ")),
+		"uname":    unameScript(p),
+		"w":        wScript(p),
+		"who":      whoScript(p),
+		"last":     lastScript(p),
+		"getent":   getentScript(p),
+		"cat":      catShimScript("/bin/cat", fakeEtc),
+		"head":     catShimScript("/usr/bin/head", fakeEtc),
+	}
+	for name, content := range scripts {
+		if err := os.WriteFile(filepath.Join(binDir, name), []byte(content), 0o755); err != nil {
+			return fmt.Errorf("write fake %s: %w", name, err)
+		}
+	}
+
+	if err := writeGitconfig(home, p); err != nil {
+		return err
+	}
+	if err := writeKnownHosts(home); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// writeExtraFiles writes the persona's ExtraFiles into home, keyed by path
+// relative to home, creating any parent directories they need. It rejects
+// any path that would escape home, so a persona file cannot use ".." to
+// write outside the session's ephemeral HOME.
+func writeExtraFiles(home string, p *persona.Persona) error {
+	for rel, content := range p.ExtraFiles {
+		full := filepath.Join(home, rel)
+		if err := checkWithinHome(home, full); err != nil {
+			return fmt.Errorf("extra file %q: %w", rel, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			return fmt.Errorf("create parent dir for extra file %q: %w", rel, err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			return fmt.Errorf("write extra file %q: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// checkWithinHome returns an error if full is not home itself or a
+// descendant of it, once both are cleaned.
+func checkWithinHome(home, full string) error {
+	rel, err := filepath.Rel(home, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("path escapes session home: %s", full)
+	}
+	return nil
+}
+
+// writeFakeEtcFiles writes the synthesized files that the cat/head shim and
+// wrapper scripts serve in place of /etc/hostname, /etc/passwd, and
+// /proc/sys/kernel/hostname.
+func writeFakeEtcFiles(fakeEtc string, p *persona.Persona) error {
+	if err := os.WriteFile(filepath.Join(fakeEtc, "hostname"), []byte(p.Host+"\n"), 0o644); err != nil {
+		return fmt.Errorf("write fake /etc/hostname: %w", err)
+	}
+
+	passwdLine := fmt.Sprintf("%s:x:%d:%d:%s:/home/%s:%s\n", p.User, p.UID, p.GID, p.GitUserName, p.User, p.Shell)
+	if err := os.WriteFile(filepath.Join(fakeEtc, "passwd"), []byte(passwdLine), 0o644); err != nil {
+		return fmt.Errorf("write fake /etc/passwd: %w", err)
+	}
+
+	return nil
+}
+
+// echoScript returns a wrapper script that unconditionally prints value.
+func echoScript(value string) string {
+	return fmt.Sprintf("#!/bin/sh\necho %s\n", shellQuote(value))
+}
+
+// idScript returns a wrapper matching the real id command's default output.
+func idScript(p *persona.Persona) string {
+	return fmt.Sprintf(`#!/bin/sh
+printf 'uid=%%d(%%s) gid=%%d(%%s) groups=%%d(%%s)\n' %[1]d %[2]s %[3]d %[2]s %[3]d %[2]s
+`, p.UID, shellQuote(p.User), p.GID)
+}
+
+// unameScript returns a wrapper honoring uname's -a, -n, and -s flags.
+func unameScript(p *persona.Persona) string {
+	return fmt.Sprintf(`#!/bin/sh
+case "$1" in
+  -n) echo %[1]s ;;
+  -s) echo "Linux" ;;
+  -a) printf 'Linux %%s 6.1.0-incogniterm #1 SMP PREEMPT x86_64 GNU/Linux\n' %[1]s ;;
+  *) echo "Linux" ;;
+esac
+`, shellQuote(p.Host))
+}
+
+// wScript returns a wrapper mimicking w's header and a single logged-in
+// session for the persona.
+func wScript(p *persona.Persona) string {
+	return fmt.Sprintf(`#!/bin/sh
+echo " up 1 day,  1 user,  load average: 0.00, 0.00, 0.00"
+echo "USER     TTY      FROM             LOGIN@   IDLE   JCPU   PCPU WHAT"
+printf '%%-8s pts/0    -                00:00    0.00s  0.00s  0.00s -bash\n' %[1]s
+`, shellQuote(p.User))
+}
+
+// whoScript returns a wrapper mimicking who's single-line output for the
+// persona's current session.
+func whoScript(p *persona.Persona) string {
+	return fmt.Sprintf(`#!/bin/sh
+printf '%%-8s pts/0        %%s\n' %[1]s "$(date '+%%Y-%%m-%%d %%H:%%M')"
+`, shellQuote(p.User))
+}
+
+// lastScript returns a wrapper mimicking last's output for a single,
+// still-logged-in session under the persona.
+func lastScript(p *persona.Persona) string {
+	return fmt.Sprintf(`#!/bin/sh
+printf '%%-8s pts/0        %%s    %%s   still logged in\n' %[1]s %[2]s "$(date)"
+echo ""
+echo "wtmp begins $(date)"
+`, shellQuote(p.User), shellQuote(p.Host))
+}
+
+// getentScript returns a wrapper supporting "getent passwd $USER" (and
+// "getent passwd" with no argument), returning the single synthesized
+// passwd line matching the persona.
+func getentScript(p *persona.Persona) string {
+	return fmt.Sprintf(`#!/bin/sh
+GETENT_USER=%[1]s
+GETENT_GECOS=%[4]s
+GETENT_SHELL=%[5]s
+if [ "$1" = "passwd" ] && { [ -z "$2" ] || [ "$2" = "$GETENT_USER" ]; }; then
+  printf '%%s:x:%%d:%%d:%%s:/home/%%s:%%s\n' "$GETENT_USER" %[2]d %[3]d "$GETENT_GECOS" "$GETENT_USER" "$GETENT_SHELL"
+  exit 0
+fi
+exit 2
+`, shellQuote(p.User), p.UID, p.GID, shellQuote(p.GitUserName), shellQuote(p.Shell))
+}
+
+// catShimScript returns a wrapper around realBin that redirects reads of
+// /etc/hostname, /etc/passwd, and /proc/sys/kernel/hostname to their
+// synthesized counterparts in fakeEtc, passing every other argument
+// through unchanged.
+func catShimScript(realBin, fakeEtc string) string {
+	return fmt.Sprintf(`#!/bin/sh
+REAL_BIN="%[1]s"
+FAKE_ETC="%[2]s"
+
+first=1
+for arg in "$@"; do
+  case "$arg" in
+    /etc/hostname|/proc/sys/kernel/hostname) arg="$FAKE_ETC/hostname" ;;
+    /etc/passwd) arg="$FAKE_ETC/passwd" ;;
+  esac
+  if [ "$first" -eq 1 ]; then
+    set -- "$arg"
+    first=0
+  else
+    set -- "$@" "$arg"
+  fi
+done
+
+exec "$REAL_BIN" "$@"
+`, realBin, fakeEtc)
+}
+
+// writeGitconfig drops a ~/.gitconfig pinning the persona's git author
+// identity, so git log/git commit show the fake identity without any
+// further setup.
+func writeGitconfig(home string, p *persona.Persona) error {
+	content := fmt.Sprintf(`[user]
+	name = %s
+	email = %s
+`, p.GitUserName, p.GitUserEmail)
+	if err := os.WriteFile(filepath.Join(home, ".gitconfig"), []byte(content), 0o600); err != nil {
+		return fmt.Errorf("write fake .gitconfig: %w", err)
+	}
+	return nil
+}
+
+// writeKnownHosts drops an empty ~/.ssh/known_hosts so SSH-aware tools
+// running inside the session don't pick up the real user's known hosts.
+func writeKnownHosts(home string) error {
+	sshDir := filepath.Join(home, ".ssh")
+	if err := os.MkdirAll(sshDir, 0o700); err != nil {
+		return fmt.Errorf("create fake .ssh: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(sshDir, "known_hosts"), nil, 0o600); err != nil {
+		return fmt.Errorf("write fake known_hosts: %w", err)
+	}
+	return nil
+}
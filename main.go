@@ -18,90 +18,87 @@
 // It is intended to be portable across Unix-like operating systems that
 // support pseudo-terminals (Linux, macOS, *BSD). Windows support depends on
 // the availability of the required PTY and terminal APIs.
+//
+// In --serve mode, incogniterm instead runs as an SSH server and hands each
+// accepted connection its own Session, so remote viewers can join a demo
+// under their own disposable identity. Connections must authenticate with a
+// key from the configured authorized_keys file. If that file has no keys and
+// --serve is bound to a loopback address, it falls back to no authentication
+// with a logged warning; on any other address it refuses to start.
 
 package main
 
 import (
+	"flag"
 	"fmt"
-	"io"
 	"log"
-	"math/rand"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"strings"
 	"syscall"
-	"time"
 
-	"github.com/brianvoe/gofakeit/v7"
-	"github.com/creack/pty"
+	"github.com/eugeniofciuvasile/incogniterm/persona"
 	"golang.org/x/term"
 )
 
 func main() {
-	seedRandom()
-	gofakeit.Seed(time.Now().UnixNano())
-
-	origDir, err := getWorkingDirectory()
-	if err != nil {
-		log.Fatalf("failed to get current dir: %v", err)
-	}
-
-	shell, shellBase := resolveShell()
-
-	tmpHome, err := createIncognitermHome()
-	if err != nil {
-		log.Fatalf("failed to create temp home: %v", err)
+	recordPath := flag.String("record", "", "record the session as an asciicast v2 file at the given path")
+	transcriptPath := flag.String("transcript", "", "log a structured recfile transcript of every command to the given path")
+	serveAddr := flag.String("serve", "", "run as an SSH server accepting demo viewers on the given address (e.g. :2222)")
+	hostKeyPath := flag.String("host-key", "", "path to the SSH host key (ed25519, auto-generated on first run)")
+	authorizedKeysPath := flag.String("authorized-keys", "", "path to an OpenSSH authorized_keys file allowing pubkey auth for --serve (default $XDG_CONFIG_HOME/incogniterm/authorized_keys); required unless --serve binds to a loopback address")
+	personaName := flag.String("persona", "", "load a persona file by name from $XDG_CONFIG_HOME/incogniterm/personas instead of generating one")
+	seed := flag.String("seed", "", "derive the generated persona deterministically from this seed")
+	flag.Parse()
+
+	if *serveAddr != "" {
+		if err := serve(*serveAddr, *hostKeyPath, *recordPath, *personaName, *seed, *transcriptPath, *authorizedKeysPath); err != nil {
+			log.Fatalf("ssh server: %v", err)
+		}
+		return
 	}
-	defer os.RemoveAll(tmpHome)
 
-	fakeUser, fakeHost := generateFakeIdentity()
-	ps1 := buildPrompt(fakeUser, fakeHost)
+	runLocal(*recordPath, *personaName, *seed, *transcriptPath)
+}
 
-	tmpBin, err := createTempBin(tmpHome)
+// runLocal runs a single Session against the local controlling terminal:
+// the shell's PTY is wired directly to this process's stdin and stdout.
+func runLocal(recordPath, personaName, seed, transcriptPath string) {
+	origDir, err := getWorkingDirectory()
 	if err != nil {
-		log.Fatalf("failed to create temp bin: %v", err)
-	}
-
-	if err := writeIdentityWrappers(tmpBin, fakeUser, fakeHost); err != nil {
-		log.Fatalf("failed to write identity wrappers: %v", err)
+		log.Fatalf("failed to get current dir: %v", err)
 	}
 
-	rcFile, err := writeShellRC(shellBase, tmpHome, fakeUser, fakeHost, ps1)
-	if err != nil {
-		log.Fatalf("failed to write shell rc: %v", err)
+	sess := NewSession(recordPath, personaName, seed, transcriptPath)
+	if err := sess.Setup(); err != nil {
+		log.Fatalf("failed to set up session: %v", err)
 	}
+	defer sess.Cleanup()
 
-	env := buildEnvironment(fakeUser, fakeHost, tmpHome, tmpBin)
-	cmd := buildShellCommand(shell, shellBase, rcFile, tmpHome, env)
-
-	if err := changeDirectory(tmpHome); err != nil {
+	if err := changeDirectory(sess.tmpHome); err != nil {
 		log.Printf("warning: failed to chdir to temp home: %v", err)
 	}
 
-	ptmx, err := startPTY(cmd, origDir)
-	if err != nil {
-		log.Fatalf("failed to start pty: %v", err)
-	}
-	defer ptmx.Close()
-
-	setupWindowResize(ptmx)
 	oldState, err := setTerminalRaw(origDir)
 	if err != nil {
 		log.Fatalf("failed to set raw mode: %v", err)
 	}
 	defer restoreTerminalAndDirectory(oldState, origDir)
 
-	startIOCopy(ptmx)
-	runShellAndExit(cmd)
-}
+	winCh := make(chan Window, 1)
+	setupLocalWindowResize(winCh)
 
-// seedRandom initializes the math/rand global source with the current time.
-// It is used to produce non-cryptographic random values for names and hostnames.
-func seedRandom() {
-	seed := time.Now().UnixNano()
-	_ = rand.NewSource(seed)
+	if err := sess.Run(os.Stdin, os.Stdout, winCh); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			// os.Exit skips deferred calls, so restore and clean up first.
+			restoreTerminalAndDirectory(oldState, origDir)
+			sess.Cleanup()
+			os.Exit(exitErr.ExitCode())
+		}
+		log.Printf("shell exited with error: %v", err)
+	}
 }
 
 // getWorkingDirectory returns the current working directory of the process.
@@ -126,25 +123,6 @@ func createIncognitermHome() (string, error) {
 	return os.MkdirTemp("", "incogniterm-home-*")
 }
 
-// generateFakeIdentity produces a fake username and hostname using gofakeit.
-// It returns the fake user and fake host as strings.
-func generateFakeIdentity() (string, string) {
-	fakeFirst := strings.ToLower(gofakeit.FirstName())
-	fakeLast := strings.ToLower(gofakeit.LastName())
-	fakeUser := fmt.Sprintf("%s_%s", fakeFirst, fakeLast)
-
-	fakeCity := strings.ToLower(strings.ReplaceAll(gofakeit.City(), " ", "-"))
-	fakeHost := fmt.Sprintf("%s-node-%d", fakeCity, rand.Intn(9000)+1000)
-
-	return fakeUser, fakeHost
-}
-
-// buildPrompt constructs a shell prompt string using the fake user and host.
-// It returns a bash-style PS1 prompt.
-func buildPrompt(fakeUser, fakeHost string) string {
-	return fmt.Sprintf("[%s@%s \\w]\\$ ", fakeUser, fakeHost)
-}
-
 // createTempBin creates a bin directory under the given home path.
 // It returns the full path to the bin directory.
 func createTempBin(home string) (string, error) {
@@ -153,37 +131,12 @@ func createTempBin(home string) (string, error) {
 	return tmpBin, err
 }
 
-// writeIdentityWrappers writes lightweight wrapper scripts for id, whoami,
-// and hostname into the specified bin directory so that they report the fake
-// identity when executed.
-func writeIdentityWrappers(binDir, fakeUser, fakeHost string) error {
-	idScript := fmt.Sprintf(`#!/bin/sh
-echo "uid=1000(%[1]s) gid=1000(%[1]s) groups=1000(%[1]s)"
-`, fakeUser)
-
-	whoamiScript := fmt.Sprintf(`#!/bin/sh
-echo "%s"
-`, fakeUser)
-
-	hostnameScript := fmt.Sprintf(`#!/bin/sh
-echo "%s"
-`, fakeHost)
-
-	if err := os.WriteFile(filepath.Join(binDir, "id"), []byte(idScript), 0o755); err != nil {
-		return fmt.Errorf("write fake id: %w", err)
-	}
-	if err := os.WriteFile(filepath.Join(binDir, "whoami"), []byte(whoamiScript), 0o755); err != nil {
-		return fmt.Errorf("write fake whoami: %w", err)
-	}
-	if err := os.WriteFile(filepath.Join(binDir, "hostname"), []byte(hostnameScript), 0o755); err != nil {
-		return fmt.Errorf("write fake hostname: %w", err)
-	}
-	return nil
-}
-
 // writeShellRC creates an appropriate shell configuration file in the
-// temporary home for the given shell. It returns the rc file path.
-func writeShellRC(shellBase, home, fakeUser, fakeHost, ps1 string) (string, error) {
+// temporary home for the given shell, using the persona's user, host, and
+// PS1. If fifoPath is non-empty, it also installs a preexec/precmd hook
+// that reports each command's boundaries to that FIFO for transcript
+// recording. It returns the rc file path.
+func writeShellRC(shellBase, home string, p *persona.Persona, fifoPath string) (string, error) {
 	switch shellBase {
 	case "bash":
 		rcFile := filepath.Join(home, ".bashrc")
@@ -191,9 +144,9 @@ func writeShellRC(shellBase, home, fakeUser, fakeHost, ps1 string) (string, erro
 export USER=%[1]s
 export LOGNAME=%[1]s
 export HOSTNAME=%[2]s
-export PS1='%[3]s'
+export PS1=%[3]s
 export HISTFILE="%[4]s/.bash_history"
-`, fakeUser, fakeHost, ps1, home)
+%[5]s`, shellQuote(p.User), shellQuote(p.Host), shellQuote(p.PS1), home, bashTranscriptHook(fifoPath))
 		if err := os.WriteFile(rcFile, []byte(rcContent), 0o600); err != nil {
 			return "", err
 		}
@@ -206,8 +159,8 @@ export USER=%[1]s
 export LOGNAME=%[1]s
 export HOSTNAME=%[2]s
 export HISTFILE="%[3]s/.zsh_history"
-PROMPT='%%F{cyan}[%[1]s@%[2]s %%~]%%f$ '
-`, fakeUser, fakeHost, home)
+PROMPT=%[4]s
+%[5]s`, shellQuote(p.User), shellQuote(p.Host), home, shellQuote(p.PS1), zshTranscriptHook(fifoPath))
 		if err := os.WriteFile(rcFile, []byte(rcContent), 0o600); err != nil {
 			return "", err
 		}
@@ -219,9 +172,9 @@ PROMPT='%%F{cyan}[%[1]s@%[2]s %%~]%%f$ '
 export USER=%[1]s
 export LOGNAME=%[1]s
 export HOSTNAME=%[2]s
-export PS1='%[3]s'
+export PS1=%[3]s
 export HISTFILE="%[4]s/.bash_history"
-`, fakeUser, fakeHost, ps1, home)
+%[5]s`, shellQuote(p.User), shellQuote(p.Host), shellQuote(p.PS1), home, bashTranscriptHook(fifoPath))
 		if err := os.WriteFile(rcFile, []byte(rcContent), 0o600); err != nil {
 			return "", err
 		}
@@ -229,15 +182,78 @@ export HISTFILE="%[4]s/.bash_history"
 	}
 }
 
-// buildEnvironment constructs the environment for the incogniterm shell
-// by overriding USER, LOGNAME, HOME, HOSTNAME and prepending the binDir
-// to PATH.
-func buildEnvironment(fakeUser, fakeHost, home, binDir string) []string {
+// bashTranscriptHook returns bash rc snippet that reports each command's
+// start, end, and exit code to fifoPath via a DEBUG trap and
+// PROMPT_COMMAND, or an empty string if fifoPath is empty.
+func bashTranscriptHook(fifoPath string) string {
+	if fifoPath == "" {
+		return ""
+	}
+	return fmt.Sprintf(`
+exec 9>"%[1]s"
+__incogniterm_last_cmd=""
+__incogniterm_start_ts=0
+__incogniterm_preexec() {
+  [ -n "$COMP_LINE" ] && return
+  [ "$BASH_COMMAND" = "__incogniterm_precmd" ] && return
+  __incogniterm_last_cmd="$BASH_COMMAND"
+  __incogniterm_start_ts=$(date +%%s.%%N)
+}
+__incogniterm_precmd() {
+  local ec=$?
+  if [ -n "$__incogniterm_last_cmd" ]; then
+    printf 'CMD\t%%s\t%%s\t%%s\t%%s\n' "$__incogniterm_last_cmd" "$__incogniterm_start_ts" "$(date +%%s.%%N)" "$ec" >&9
+  fi
+  __incogniterm_last_cmd=""
+}
+trap '__incogniterm_preexec' DEBUG
+PROMPT_COMMAND="__incogniterm_precmd"
+__incogniterm_last_cmd=""
+`, fifoPath)
+}
+
+// zshTranscriptHook returns a zsh rc snippet that reports each command's
+// start, end, and exit code to fifoPath via zsh's native preexec/precmd
+// hooks, or an empty string if fifoPath is empty.
+func zshTranscriptHook(fifoPath string) string {
+	if fifoPath == "" {
+		return ""
+	}
+	return fmt.Sprintf(`
+exec 9>"%[1]s"
+__incogniterm_last_cmd=""
+__incogniterm_start_ts=0
+preexec() {
+  __incogniterm_last_cmd="$1"
+  __incogniterm_start_ts=$(date +%%s.%%N)
+}
+precmd() {
+  local ec=$?
+  if [ -n "$__incogniterm_last_cmd" ]; then
+    printf 'CMD\t%%s\t%%s\t%%s\t%%s\n' "$__incogniterm_last_cmd" "$__incogniterm_start_ts" "$(date +%%s.%%N)" "$ec" >&9
+  fi
+  __incogniterm_last_cmd=""
+}
+`, fifoPath)
+}
+
+// buildEnvironment constructs the environment for the incogniterm shell by
+// overriding USER, LOGNAME, HOME, HOSTNAME, the git author/committer
+// identity, applying the persona's extra environment variables, and
+// prepending binDir to PATH.
+func buildEnvironment(home, binDir string, p *persona.Persona) []string {
 	env := os.Environ()
-	env = overrideEnv(env, "USER", fakeUser)
-	env = overrideEnv(env, "LOGNAME", fakeUser)
+	env = overrideEnv(env, "USER", p.User)
+	env = overrideEnv(env, "LOGNAME", p.User)
 	env = overrideEnv(env, "HOME", home)
-	env = overrideEnv(env, "HOSTNAME", fakeHost)
+	env = overrideEnv(env, "HOSTNAME", p.Host)
+	env = overrideEnv(env, "GIT_AUTHOR_NAME", p.GitUserName)
+	env = overrideEnv(env, "GIT_AUTHOR_EMAIL", p.GitUserEmail)
+	env = overrideEnv(env, "GIT_COMMITTER_NAME", p.GitUserName)
+	env = overrideEnv(env, "GIT_COMMITTER_EMAIL", p.GitUserEmail)
+	for k, v := range p.ExtraEnv {
+		env = overrideEnv(env, k, v)
+	}
 	env = prependPath(env, binDir)
 	return env
 }
@@ -265,25 +281,17 @@ func changeDirectory(dir string) error {
 	return os.Chdir(dir)
 }
 
-// startPTY starts the given command attached to a pseudo-terminal.
-// On failure, it restores the original directory and returns an error.
-func startPTY(cmd *exec.Cmd, origDir string) (*os.File, error) {
-	ptmx, err := pty.Start(cmd)
-	if err != nil {
-		_ = os.Chdir(origDir)
-		return nil, err
-	}
-	return ptmx, nil
-}
-
-// setupWindowResize installs a SIGWINCH handler that keeps the PTY size
-// in sync with the parent terminal window size.
-func setupWindowResize(ptmx *os.File) {
+// setupLocalWindowResize installs a SIGWINCH handler that pushes the
+// current terminal size onto winCh whenever the parent terminal window is
+// resized, including an initial size on startup.
+func setupLocalWindowResize(winCh chan<- Window) {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGWINCH)
 	go func() {
 		for range ch {
-			_ = pty.InheritSize(os.Stdin, ptmx)
+			if cols, rows, err := term.GetSize(int(os.Stdin.Fd())); err == nil {
+				winCh <- Window{Cols: cols, Rows: rows}
+			}
 		}
 	}()
 	ch <- syscall.SIGWINCH
@@ -308,26 +316,6 @@ func restoreTerminalAndDirectory(oldState *term.State, origDir string) {
 	_ = os.Chdir(origDir)
 }
 
-// startIOCopy starts copying data between stdin and the PTY and then from
-// the PTY to stdout. It runs the stdin->PTY copy in a goroutine.
-func startIOCopy(ptmx *os.File) {
-	go func() {
-		_, _ = io.Copy(ptmx, os.Stdin)
-	}()
-	_, _ = io.Copy(os.Stdout, ptmx)
-}
-
-// runShellAndExit waits for the shell command to finish and then exits
-// the incogniterm process with the same exit code, if available.
-func runShellAndExit(cmd *exec.Cmd) {
-	if err := cmd.Wait(); err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			os.Exit(exitErr.ExitCode())
-		}
-		log.Printf("shell exited with error: %v", err)
-	}
-}
-
 // overrideEnv sets or replaces an environment variable in the provided
 // slice of "key=value" strings and returns the updated slice.
 func overrideEnv(env []string, key, value string) []string {
@@ -0,0 +1,181 @@
+// Package persona resolves the fake identity presented to an incogniterm
+// session: either generated on the fly (optionally seeded for determinism)
+// or loaded from a persona file committed alongside a demo.
+package persona
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"gopkg.in/yaml.v3"
+)
+
+// Persona describes the fake identity and environment presented to a shell
+// during an incogniterm session: the reported user, host, and credentials,
+// plus any extra environment variables or files the demo needs.
+type Persona struct {
+	User         string            `yaml:"user" json:"user"`
+	Host         string            `yaml:"host" json:"host"`
+	UID          int               `yaml:"uid" json:"uid"`
+	GID          int               `yaml:"gid" json:"gid"`
+	Groups       []string          `yaml:"groups" json:"groups"`
+	Shell        string            `yaml:"shell" json:"shell"`
+	GitUserName  string            `yaml:"git_user_name" json:"git_user_name"`
+	GitUserEmail string            `yaml:"git_user_email" json:"git_user_email"`
+	PS1          string            `yaml:"ps1" json:"ps1"`
+	ExtraEnv     map[string]string `yaml:"extra_env" json:"extra_env"`
+	// ExtraFiles maps a path relative to the session's ephemeral HOME to the
+	// literal content to write there (e.g. ".vimrc", ".config/tool/config"),
+	// overwriting anything incogniterm would otherwise have put there.
+	ExtraFiles map[string]string `yaml:"extra_files" json:"extra_files"`
+}
+
+// Resolve returns the Persona to use for a session: the named persona file
+// if name is non-empty, otherwise a freshly generated one. When seed is
+// non-empty, generation is deterministic, so the same seed always produces
+// the same user, host, and prompt.
+func Resolve(name, seed string) (*Persona, error) {
+	if name != "" {
+		path, err := find(name)
+		if err != nil {
+			return nil, err
+		}
+		return Load(path)
+	}
+	return Generate(seed), nil
+}
+
+// Dir returns the directory persona files are loaded from:
+// $XDG_CONFIG_HOME/incogniterm/personas (or its platform equivalent).
+func Dir() (string, error) {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "incogniterm", "personas"), nil
+}
+
+// find locates the persona file named name (without extension) in Dir,
+// trying the .yaml, .yml, and .json extensions in turn.
+func find(name string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	for _, ext := range []string{".yaml", ".yml", ".json"} {
+		path := filepath.Join(dir, name+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("persona %q not found in %s", name, dir)
+}
+
+// Load reads and parses a persona file, choosing YAML or JSON based on its
+// file extension, and fills in any fields the file left unset.
+func Load(path string) (*Persona, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read persona file: %w", err)
+	}
+
+	var p Persona
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(data, &p); err != nil {
+			return nil, fmt.Errorf("parse persona file: %w", err)
+		}
+	} else {
+		if err := yaml.Unmarshal(data, &p); err != nil {
+			return nil, fmt.Errorf("parse persona file: %w", err)
+		}
+	}
+
+	p.applyDefaults()
+	return &p, nil
+}
+
+// Generate produces a new Persona using gofakeit. If seed is non-empty, the
+// underlying random source is derived deterministically via fnv64a(seed),
+// so the same seed always yields the same persona; otherwise it is random.
+func Generate(seed string) *Persona {
+	faker := newFaker(seed)
+
+	fakeFirst := strings.ToLower(faker.FirstName())
+	fakeLast := strings.ToLower(faker.LastName())
+	user := fmt.Sprintf("%s_%s", fakeFirst, fakeLast)
+
+	city := strings.ToLower(strings.ReplaceAll(faker.City(), " ", "-"))
+	host := fmt.Sprintf("%s-node-%d", city, faker.Number(1000, 9999))
+
+	p := &Persona{User: user, Host: host, PS1: coloredPS1(faker, user, host)}
+	p.applyDefaults()
+	return p
+}
+
+// ps1Colors are the ANSI SGR codes Generate picks a prompt color from.
+var ps1Colors = []string{"31", "32", "33", "34", "35", "36"}
+
+// coloredPS1 builds a bash/readline-safe PS1 for user and host, colored with
+// an ANSI code chosen from faker so the same seed always yields the same
+// color.
+func coloredPS1(faker *gofakeit.Faker, user, host string) string {
+	color := faker.RandomString(ps1Colors)
+	return fmt.Sprintf("\\[\\e[%sm\\][%s@%s \\w]\\[\\e[0m\\]\\$ ", color, user, host)
+}
+
+// newFaker returns a gofakeit.Faker seeded deterministically from seed via
+// fnv64a, or from the current time if seed is empty.
+func newFaker(seed string) *gofakeit.Faker {
+	if seed == "" {
+		return gofakeit.New(uint64(time.Now().UnixNano()))
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(seed))
+	return gofakeit.New(h.Sum64())
+}
+
+// applyDefaults fills in any field left unset, deriving it from the
+// persona's user and host where possible.
+func (p *Persona) applyDefaults() {
+	if p.User == "" {
+		p.User = "guest"
+	}
+	if p.Host == "" {
+		p.Host = "localhost"
+	}
+	if p.UID == 0 {
+		p.UID = 1000
+	}
+	if p.GID == 0 {
+		p.GID = 1000
+	}
+	if len(p.Groups) == 0 {
+		p.Groups = []string{p.User}
+	}
+	if p.Shell == "" {
+		p.Shell = "/bin/bash"
+	}
+	if p.GitUserName == "" {
+		p.GitUserName = capitalize(p.User)
+	}
+	if p.GitUserEmail == "" {
+		p.GitUserEmail = fmt.Sprintf("%s@%s.example", p.User, p.Host)
+	}
+	if p.PS1 == "" {
+		p.PS1 = fmt.Sprintf("[%s@%s \\w]\\$ ", p.User, p.Host)
+	}
+}
+
+// capitalize upper-cases the first byte of s, leaving the rest untouched.
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
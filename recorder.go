@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// asciicastHeader is the first line of an asciicast v2 file, describing the
+// recorded terminal's dimensions and environment.
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env"`
+}
+
+// Recorder writes a PTY session to a file in asciicast v2 format: a header
+// line followed by one JSON array event per output chunk or resize.
+type Recorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	start time.Time
+	enc   *json.Encoder
+}
+
+// NewRecorder creates the recording file at path and writes the asciicast v2
+// header using the given initial PTY dimensions. It returns the Recorder
+// ready to accept output and resize events.
+func NewRecorder(path string, cols, rows int) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	header := asciicastHeader{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: time.Now().Unix(),
+		Env: map[string]string{
+			"SHELL": os.Getenv("SHELL"),
+			"TERM":  os.Getenv("TERM"),
+		},
+	}
+
+	enc := json.NewEncoder(f)
+	if err := enc.Encode(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &Recorder{file: f, start: time.Now(), enc: enc}, nil
+}
+
+// elapsed returns the number of seconds since the recording started.
+func (r *Recorder) elapsed() float64 {
+	return time.Since(r.start).Seconds()
+}
+
+// WriteOutput appends an "o" (output) event containing chunk, a UTF-8
+// decoded slice of PTY output, to the recording.
+func (r *Recorder) WriteOutput(chunk []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode([]any{r.elapsed(), "o", string(chunk)})
+}
+
+// WriteResize appends an "r" (resize) event in "COLSxROWS" form so that
+// replays resize the terminal at the right moment.
+func (r *Recorder) WriteResize(cols, rows int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode([]any{r.elapsed(), "r", fmtResize(cols, rows)})
+}
+
+// fmtResize formats a terminal size as the "COLSxROWS" string used by
+// asciicast resize events.
+func fmtResize(cols, rows int) string {
+	return strconv.Itoa(cols) + "x" + strconv.Itoa(rows)
+}
+
+// Close flushes and closes the underlying recording file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
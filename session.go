@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"github.com/creack/pty"
+	"github.com/eugeniofciuvasile/incogniterm/persona"
+)
+
+// Window describes a terminal size in columns and rows. It is used to
+// notify a running Session of resize events regardless of whether they
+// originate from a local SIGWINCH or an SSH window-change request.
+type Window struct {
+	Cols int
+	Rows int
+}
+
+// Session represents one incogniterm identity session: an ephemeral HOME,
+// wrapper bin directory, shell configuration, and the shell process running
+// inside a PTY. The same Session type backs both the local TTY invocation
+// and each connection accepted in --serve mode.
+type Session struct {
+	recordPath     string
+	personaName    string
+	seed           string
+	transcriptPath string
+
+	shell     string
+	shellBase string
+	tmpHome   string
+	tmpBin    string
+	fifoPath  string
+	persona   *persona.Persona
+	rcFile    string
+	env       []string
+
+	cmd        *exec.Cmd
+	ptmx       *os.File
+	rec        *Recorder
+	transcript *Transcript
+}
+
+// NewSession creates a Session that will record its output to recordPath
+// (if non-empty), log a recfile transcript to transcriptPath (if
+// non-empty), and resolve its identity from personaName/seed as
+// persona.Resolve would.
+func NewSession(recordPath, personaName, seed, transcriptPath string) *Session {
+	return &Session{
+		recordPath:     recordPath,
+		personaName:    personaName,
+		seed:           seed,
+		transcriptPath: transcriptPath,
+	}
+}
+
+// Setup resolves the session's Persona, and creates the ephemeral HOME,
+// wrapper bin directory, and shell configuration to match it. It must be
+// called before Run.
+func (s *Session) Setup() error {
+	s.shell, s.shellBase = resolveShell()
+
+	tmpHome, err := createIncognitermHome()
+	if err != nil {
+		return fmt.Errorf("create temp home: %w", err)
+	}
+	s.tmpHome = tmpHome
+
+	p, err := persona.Resolve(s.personaName, s.seed)
+	if err != nil {
+		return fmt.Errorf("resolve persona: %w", err)
+	}
+	s.persona = p
+
+	tmpBin, err := createTempBin(tmpHome)
+	if err != nil {
+		return fmt.Errorf("create temp bin: %w", err)
+	}
+	s.tmpBin = tmpBin
+
+	if err := writeIdentityWrappers(tmpHome, tmpBin, p); err != nil {
+		return fmt.Errorf("write identity wrappers: %w", err)
+	}
+
+	if s.transcriptPath != "" {
+		fifoPath := filepath.Join(tmpHome, ".incogniterm-transcript.fifo")
+		if err := syscall.Mkfifo(fifoPath, 0o600); err != nil {
+			return fmt.Errorf("create transcript fifo: %w", err)
+		}
+		s.fifoPath = fifoPath
+	}
+
+	rcFile, err := writeShellRC(s.shellBase, tmpHome, p, s.fifoPath)
+	if err != nil {
+		return fmt.Errorf("write shell rc: %w", err)
+	}
+	s.rcFile = rcFile
+
+	if err := writeExtraFiles(tmpHome, p); err != nil {
+		return fmt.Errorf("write persona extra files: %w", err)
+	}
+
+	s.env = buildEnvironment(tmpHome, tmpBin, p)
+	s.cmd = buildShellCommand(s.shell, s.shellBase, rcFile, tmpHome, s.env)
+	return nil
+}
+
+// Run starts the session's shell in a PTY wired to stdin and stdout and
+// blocks until the shell exits. Resize events received on winCh are applied
+// to the PTY as they arrive. If the session was created with a record path,
+// the PTY output and every resize are also written to the recording.
+func (s *Session) Run(stdin io.Reader, stdout io.Writer, winCh <-chan Window) error {
+	ptmx, err := pty.Start(s.cmd)
+	if err != nil {
+		return fmt.Errorf("start pty: %w", err)
+	}
+	s.ptmx = ptmx
+
+	if s.recordPath != "" {
+		size, err := pty.GetsizeFull(ptmx)
+		if err != nil {
+			return fmt.Errorf("get pty size: %w", err)
+		}
+		rec, err := NewRecorder(s.recordPath, int(size.Cols), int(size.Rows))
+		if err != nil {
+			return fmt.Errorf("start recording: %w", err)
+		}
+		s.rec = rec
+	}
+
+	if s.transcriptPath != "" {
+		transcript, err := NewTranscript(s.transcriptPath)
+		if err != nil {
+			return fmt.Errorf("start transcript: %w", err)
+		}
+		s.transcript = transcript
+		go watchCommandFIFO(s.fifoPath, transcript)
+	}
+
+	go func() {
+		for win := range winCh {
+			_ = pty.Setsize(ptmx, &pty.Winsize{Cols: uint16(win.Cols), Rows: uint16(win.Rows)})
+			if s.rec != nil {
+				_ = s.rec.WriteResize(win.Cols, win.Rows)
+			}
+		}
+	}()
+
+	go func() {
+		_, _ = io.Copy(ptmx, stdin)
+	}()
+
+	writers := []io.Writer{stdout}
+	if s.rec != nil {
+		writers = append(writers, nonFatalWriter{recorderWriter{s.rec}, "recording"})
+	}
+	if s.transcript != nil {
+		writers = append(writers, nonFatalWriter{s.transcript, "transcript"})
+	}
+	out := io.MultiWriter(writers...)
+	_, _ = io.Copy(out, ptmx)
+
+	return s.cmd.Wait()
+}
+
+// Cleanup closes the PTY, flushes and closes any recording, and removes the
+// session's ephemeral HOME and everything written into it.
+func (s *Session) Cleanup() {
+	if s.ptmx != nil {
+		_ = s.ptmx.Close()
+	}
+	if s.rec != nil {
+		_ = s.rec.Close()
+	}
+	if s.transcript != nil {
+		_ = s.transcript.Close()
+	}
+	if s.tmpHome != "" {
+		_ = os.RemoveAll(s.tmpHome)
+	}
+}
+
+// recorderWriter adapts a *Recorder to io.Writer so it can be used as one
+// leg of an io.MultiWriter alongside the real session output.
+type recorderWriter struct {
+	rec *Recorder
+}
+
+func (w recorderWriter) Write(p []byte) (int, error) {
+	if err := w.rec.WriteOutput(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// nonFatalWriter adapts an io.Writer for use as an optional leg of the
+// io.MultiWriter driving PTY output: a write error is logged and reported
+// as a full, successful write instead of being propagated, so a failing
+// recording or transcript can never stop output from reaching the real
+// terminal (the other, load-bearing leg of the same MultiWriter).
+type nonFatalWriter struct {
+	w     io.Writer
+	label string
+}
+
+func (w nonFatalWriter) Write(p []byte) (int, error) {
+	if _, err := w.w.Write(p); err != nil {
+		log.Printf("%s write failed, continuing without it: %v", w.label, err)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// handleSFTPSubsystem serves an SFTP session over channel, rooted at root
+// (the session's ephemeral HOME). It blocks until the client disconnects or
+// the subsystem errors.
+func handleSFTPSubsystem(channel ssh.Channel, root string) error {
+	fs, err := newRootedFS(root)
+	if err != nil {
+		return fmt.Errorf("init sftp root: %w", err)
+	}
+
+	handlers := sftp.Handlers{
+		FileGet:  fs,
+		FilePut:  fs,
+		FileCmd:  fs,
+		FileList: fs,
+	}
+
+	server := sftp.NewRequestServer(channel, handlers)
+	defer server.Close()
+	return server.Serve()
+}
+
+// rootedFS implements sftp.FileReader, FileWriter, FileCmder, and FileLister
+// confined to a directory tree rooted at root. Every path is resolved with
+// resolve before use, which rejects any path that would escape root,
+// including escapes via symlinks, so an SFTP client cannot read or write
+// outside the session's ephemeral HOME.
+type rootedFS struct {
+	root string
+}
+
+// newRootedFS creates a rootedFS anchored at root, which must already exist.
+func newRootedFS(root string) (*rootedFS, error) {
+	resolved, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	return &rootedFS{root: resolved}, nil
+}
+
+// resolve maps an SFTP path (always absolute and slash-separated, per the
+// protocol) onto a real filesystem path beneath fs.root. It rejects any
+// path whose cleaned form, or whose resolved symlink target, falls outside
+// fs.root.
+func (fs *rootedFS) resolve(name string) (string, error) {
+	clean := filepath.Clean(string(filepath.Separator) + name)
+	full := filepath.Join(fs.root, clean)
+
+	if err := fs.checkWithin(full); err != nil {
+		return "", err
+	}
+
+	resolved, err := filepath.EvalSymlinks(full)
+	if err != nil {
+		// full does not exist yet (e.g. a file about to be created), so
+		// check where its parent directory really lives instead.
+		resolved, err = filepath.EvalSymlinks(filepath.Dir(full))
+	}
+	if err == nil {
+		if err := fs.checkWithin(resolved); err != nil {
+			return "", fmt.Errorf("path escapes session root via symlink: %s", name)
+		}
+	}
+
+	return full, nil
+}
+
+// checkWithin returns an error if full is not fs.root itself or a
+// descendant of it.
+func (fs *rootedFS) checkWithin(full string) error {
+	rel, err := filepath.Rel(fs.root, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("path escapes session root: %s", full)
+	}
+	return nil
+}
+
+func (fs *rootedFS) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	path, err := fs.resolve(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (fs *rootedFS) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	path, err := fs.resolve(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+}
+
+func (fs *rootedFS) Filecmd(r *sftp.Request) error {
+	path, err := fs.resolve(r.Filepath)
+	if err != nil {
+		return err
+	}
+
+	switch r.Method {
+	case "Setstat":
+		return nil
+	case "Rename":
+		target, err := fs.resolve(r.Target)
+		if err != nil {
+			return err
+		}
+		return os.Rename(path, target)
+	case "Rmdir", "Remove":
+		return os.Remove(path)
+	case "Mkdir":
+		return os.Mkdir(path, 0o755)
+	default:
+		return fmt.Errorf("unsupported sftp command: %s", r.Method)
+	}
+}
+
+func (fs *rootedFS) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	path, err := fs.resolve(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch r.Method {
+	case "List":
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		infos := make([]os.FileInfo, 0, len(entries))
+		for _, e := range entries {
+			if info, err := e.Info(); err == nil {
+				infos = append(infos, info)
+			}
+		}
+		return fileInfoListerAt(infos), nil
+	case "Stat", "Lstat":
+		info, err := os.Lstat(path)
+		if err != nil {
+			return nil, err
+		}
+		return fileInfoListerAt([]os.FileInfo{info}), nil
+	default:
+		return nil, fmt.Errorf("unsupported sftp list method: %s", r.Method)
+	}
+}
+
+// fileInfoListerAt adapts a slice of os.FileInfo to sftp.ListerAt.
+type fileInfoListerAt []os.FileInfo
+
+func (l fileInfoListerAt) ListAt(dst []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(dst, l[offset:])
+	if n < len(dst) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestRootedFS(t *testing.T) *rootedFS {
+	t.Helper()
+	root := t.TempDir()
+	fs, err := newRootedFS(root)
+	if err != nil {
+		t.Fatalf("newRootedFS: %v", err)
+	}
+	return fs
+}
+
+func TestRootedFSResolveConfinesDotDotTraversal(t *testing.T) {
+	fs := newTestRootedFS(t)
+
+	path, err := fs.resolve("../../../etc/passwd")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if err := fs.checkWithin(path); err != nil {
+		t.Fatalf("resolved path escaped root: %s: %v", path, err)
+	}
+}
+
+func TestRootedFSResolveConfinesAbsolutePath(t *testing.T) {
+	fs := newTestRootedFS(t)
+
+	path, err := fs.resolve("/etc/passwd")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if err := fs.checkWithin(path); err != nil {
+		t.Fatalf("resolved path %q is not under root %q: %v", path, fs.root, err)
+	}
+}
+
+func TestRootedFSResolveRejectsSymlinkEscape(t *testing.T) {
+	fs := newTestRootedFS(t)
+
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("shh"), 0o644); err != nil {
+		t.Fatalf("write outside file: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(fs.root, "escape")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	if _, err := fs.resolve("escape/secret"); err == nil {
+		t.Fatal("expected resolve to reject a path escaping root via a symlink")
+	}
+}
+
+func TestRootedFSCheckWithinRejectsSiblingPath(t *testing.T) {
+	fs := newTestRootedFS(t)
+
+	sibling := filepath.Clean(fs.root) + "-sibling"
+	if err := fs.checkWithin(sibling); err == nil {
+		t.Fatalf("expected checkWithin to reject sibling path %q", sibling)
+	}
+}
+
+func TestRootedFSCheckWithinAcceptsRootItself(t *testing.T) {
+	fs := newTestRootedFS(t)
+
+	if err := fs.checkWithin(fs.root); err != nil {
+		t.Fatalf("checkWithin rejected root itself: %v", err)
+	}
+}
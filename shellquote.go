@@ -0,0 +1,12 @@
+package main
+
+import "strings"
+
+// shellQuote returns s as a single POSIX shell word: wrapped in single
+// quotes, with any embedded single quotes escaped. Persona fields are
+// attacker-controlled (a persona file can ship arbitrary strings), so every
+// site that splices one into generated rc or wrapper script text must quote
+// it with this before interpolating, rather than embedding it raw.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
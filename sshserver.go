@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// serve runs incogniterm as an SSH server on addr. Each accepted connection
+// gets its own disposable identity, ephemeral HOME, wrapper bin directory,
+// and PTY, following the same Session lifecycle as the local TTY mode. This
+// makes incogniterm usable for classroom recordings where students SSH into
+// the presenter's box under disposable identities.
+//
+// Clients must authenticate against authorizedKeysPath (an OpenSSH
+// authorized_keys file; defaults to
+// $XDG_CONFIG_HOME/incogniterm/authorized_keys). If that file has no keys,
+// serve only allows unauthenticated clients on a loopback addr, and refuses
+// to start on any other address.
+func serve(addr, hostKeyPath, recordPath, personaName, seed, transcriptPath, authorizedKeysPath string) error {
+	signer, err := loadOrCreateHostKey(hostKeyPath)
+	if err != nil {
+		return fmt.Errorf("load host key: %w", err)
+	}
+
+	if authorizedKeysPath == "" {
+		dir, err := configDir()
+		if err != nil {
+			return err
+		}
+		authorizedKeysPath = filepath.Join(dir, "authorized_keys")
+	}
+	authorizedKeys, err := loadAuthorizedKeys(authorizedKeysPath)
+	if err != nil {
+		return err
+	}
+
+	config := &ssh.ServerConfig{}
+	switch {
+	case len(authorizedKeys) > 0:
+		config.PublicKeyCallback = authorizedKeyCallback(authorizedKeys)
+	case isLoopbackAddr(addr):
+		log.Printf("incogniterm: WARNING: no authorized keys in %s; accepting any client on loopback address %s with no authentication", authorizedKeysPath, addr)
+		config.NoClientAuth = true
+	default:
+		return fmt.Errorf("refusing to serve %s with no authorized keys in %s: add public keys there (or pass --authorized-keys), or bind to a loopback address", addr, authorizedKeysPath)
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", addr, err)
+	}
+	defer listener.Close()
+
+	log.Printf("incogniterm: serving disposable identities over SSH on %s", addr)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("accept: %v", err)
+			continue
+		}
+		go handleConn(conn, config, recordPath, personaName, seed, transcriptPath)
+	}
+}
+
+// loadAuthorizedKeys parses an OpenSSH authorized_keys file into the public
+// keys it grants access to. A missing file is not an error: it just means
+// no keys are configured, and serve falls back to its loopback-only default.
+func loadAuthorizedKeys(path string) ([]ssh.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read authorized keys: %w", err)
+	}
+
+	var keys []ssh.PublicKey
+	for len(data) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse authorized keys: %w", err)
+		}
+		keys = append(keys, key)
+		data = bytes.TrimSpace(rest)
+	}
+	return keys, nil
+}
+
+// authorizedKeyCallback returns an ssh.ServerConfig.PublicKeyCallback that
+// accepts only keys matching one of allowed.
+func authorizedKeyCallback(allowed []ssh.PublicKey) func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+	return func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		marshaled := key.Marshal()
+		for _, k := range allowed {
+			if bytes.Equal(k.Marshal(), marshaled) {
+				return &ssh.Permissions{}, nil
+			}
+		}
+		return nil, fmt.Errorf("unauthorized public key for user %q", meta.User())
+	}
+}
+
+// isLoopbackAddr reports whether addr's host is the loopback interface. An
+// empty host (e.g. ":2222", which binds every interface) is not loopback.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+	return host == "localhost"
+}
+
+// handleConn performs the SSH handshake for a single connection and
+// services every channel it opens. recordPath and transcriptPath are
+// suffixed with a per-connection tag so that concurrent viewers never
+// truncate each other's recording.
+func handleConn(conn net.Conn, config *ssh.ServerConfig, recordPath, personaName, seed, transcriptPath string) {
+	defer conn.Close()
+
+	suffix := connSuffix(conn.RemoteAddr())
+	recordPath = perConnPath(recordPath, suffix)
+	transcriptPath = perConnPath(transcriptPath, suffix)
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		log.Printf("ssh handshake from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer sconn.Close()
+
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			log.Printf("accept channel: %v", err)
+			continue
+		}
+		go handleSessionChannel(channel, requests, recordPath, personaName, seed, transcriptPath)
+	}
+}
+
+// connCounter disambiguates connSuffix when two connections share a remote
+// address (e.g. several viewers behind the same NAT or on localhost).
+var connCounter uint64
+
+// connSuffix returns a filesystem-safe, per-connection tag derived from
+// remoteAddr, unique even across connections from the same address.
+func connSuffix(remoteAddr net.Addr) string {
+	r := strings.NewReplacer(":", "-", "/", "-", "[", "", "]", "")
+	n := atomic.AddUint64(&connCounter, 1)
+	return fmt.Sprintf("%s-%d", r.Replace(remoteAddr.String()), n)
+}
+
+// perConnPath inserts suffix before base's extension, or returns base
+// unchanged if it is empty (recording/transcript disabled).
+func perConnPath(base, suffix string) string {
+	if base == "" {
+		return ""
+	}
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "-" + suffix + ext
+}
+
+// ptyRequestPayload mirrors the RFC 4254 pty-req message body.
+type ptyRequestPayload struct {
+	Term   string
+	Cols   uint32
+	Rows   uint32
+	Width  uint32
+	Height uint32
+	Modes  string
+}
+
+// windowChangePayload mirrors the RFC 4254 window-change message body.
+type windowChangePayload struct {
+	Cols   uint32
+	Rows   uint32
+	Width  uint32
+	Height uint32
+}
+
+// subsystemPayload mirrors the RFC 4254 subsystem message body.
+type subsystemPayload struct {
+	Name string
+}
+
+// sendWindow delivers win to winCh without blocking. Nothing reads winCh
+// until Session.Run's resize goroutine starts, which never happens if
+// pty.Start fails; a plain send would then block the request loop forever
+// on a second pty-req/window-change. Dropping a resize in the rare case the
+// buffer is already full is harmless, since a later one will get through.
+func sendWindow(winCh chan<- Window, win Window) {
+	select {
+	case winCh <- win:
+	default:
+	}
+}
+
+// handleSessionChannel services the pty-req, window-change, shell, exec,
+// and subsystem requests for a single SSH session channel. It runs a fresh
+// Session for the lifetime of the channel and cleans it up on disconnect.
+func handleSessionChannel(channel ssh.Channel, requests <-chan *ssh.Request, recordPath, personaName, seed, transcriptPath string) {
+	defer channel.Close()
+
+	sess := NewSession(recordPath, personaName, seed, transcriptPath)
+	if err := sess.Setup(); err != nil {
+		log.Printf("session setup: %v", err)
+		return
+	}
+	defer sess.Cleanup()
+
+	winCh := make(chan Window, 1)
+	started := false
+
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			var payload ptyRequestPayload
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			sendWindow(winCh, Window{Cols: int(payload.Cols), Rows: int(payload.Rows)})
+			_ = req.Reply(true, nil)
+
+		case "window-change":
+			var payload windowChangePayload
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				continue
+			}
+			sendWindow(winCh, Window{Cols: int(payload.Cols), Rows: int(payload.Rows)})
+
+		case "shell", "exec":
+			_ = req.Reply(true, nil)
+			if started {
+				continue
+			}
+			started = true
+			go func() {
+				if err := sess.Run(channel, channel, winCh); err != nil {
+					log.Printf("session run: %v", err)
+				}
+				_ = channel.Close()
+			}()
+
+		case "subsystem":
+			var payload subsystemPayload
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "sftp" {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			if started {
+				continue
+			}
+			started = true
+			go func() {
+				if err := handleSFTPSubsystem(channel, sess.tmpHome); err != nil {
+					log.Printf("sftp subsystem: %v", err)
+				}
+				_ = channel.Close()
+			}()
+
+		default:
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// loadOrCreateHostKey loads an ed25519 SSH host key from path, generating
+// and persisting a new one on first run. An empty path defaults to
+// $XDG_CONFIG_HOME/incogniterm/host_key (or its platform equivalent).
+func loadOrCreateHostKey(path string) (ssh.Signer, error) {
+	if path == "" {
+		dir, err := configDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(dir, "host_key")
+	}
+
+	if data, err := os.ReadFile(path); err == nil {
+		return ssh.ParsePrivateKey(data)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate host key: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(priv, "incogniterm host key")
+	if err != nil {
+		return nil, fmt.Errorf("marshal host key: %w", err)
+	}
+	pemBytes := pem.EncodeToMemory(block)
+
+	if err := os.WriteFile(path, pemBytes, 0o600); err != nil {
+		return nil, fmt.Errorf("write host key: %w", err)
+	}
+
+	return ssh.ParsePrivateKey(pemBytes)
+}
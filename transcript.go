@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transcript records one recfile-format record per shell command executed
+// during a session: Command, StartedAt, Duration, ExitCode, and the PTY
+// output produced while it ran, base64-encoded. Records are separated by a
+// blank line, as in a GNU recfile.
+type Transcript struct {
+	mu   sync.Mutex
+	file *os.File
+	buf  bytes.Buffer
+}
+
+// NewTranscript creates the transcript file at path, ready to accumulate
+// PTY output and command records.
+func NewTranscript(path string) (*Transcript, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Transcript{file: f}, nil
+}
+
+// Write appends PTY output to the buffer accumulated for the command
+// currently in flight. It implements io.Writer so a Transcript can be used
+// as one leg of an io.MultiWriter alongside the terminal and any asciicast
+// recording.
+func (t *Transcript) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.buf.Write(p)
+}
+
+// RecordCommand writes a record for one completed command, using the PTY
+// output accumulated since the previous record (or since the transcript
+// started) as its Output field.
+func (t *Transcript) RecordCommand(command string, startedAt, endedAt time.Time, exitCode int) error {
+	t.mu.Lock()
+	output := append([]byte(nil), t.buf.Bytes()...)
+	t.buf.Reset()
+
+	record := fmt.Sprintf(
+		"Command: %s\nStartedAt: %s\nDuration: %.3fs\nExitCode: %d\nOutput: |%s|\n\n",
+		command,
+		startedAt.Format(time.RFC3339Nano),
+		endedAt.Sub(startedAt).Seconds(),
+		exitCode,
+		base64.StdEncoding.EncodeToString(output),
+	)
+	_, err := t.file.WriteString(record)
+	t.mu.Unlock()
+	return err
+}
+
+// Close flushes and closes the underlying transcript file.
+func (t *Transcript) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.file.Close()
+}
+
+// watchCommandFIFO reads newline-delimited command markers from fifoPath,
+// written by the generated shell rc's preexec/precmd hooks, and turns each
+// into a Transcript record. It returns once the FIFO's writer closes,
+// which happens when the session's shell exits.
+func watchCommandFIFO(fifoPath string, t *Transcript) {
+	f, err := os.OpenFile(fifoPath, os.O_RDONLY, 0)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parseCommandMarker(scanner.Text(), t)
+	}
+}
+
+// parseCommandMarker parses one "CMD\t<command>\t<startedAt>\t<endedAt>\t<exitCode>"
+// line, with timestamps as Unix seconds with fractional nanoseconds, and
+// records it on t.
+func parseCommandMarker(line string, t *Transcript) {
+	fields := strings.SplitN(line, "\t", 5)
+	if len(fields) != 5 || fields[0] != "CMD" {
+		return
+	}
+
+	command := fields[1]
+	start, errStart := strconv.ParseFloat(fields[2], 64)
+	end, errEnd := strconv.ParseFloat(fields[3], 64)
+	exitCode, errExit := strconv.Atoi(fields[4])
+	if errStart != nil || errEnd != nil || errExit != nil {
+		return
+	}
+
+	startedAt := time.Unix(0, int64(start*float64(time.Second)))
+	endedAt := time.Unix(0, int64(end*float64(time.Second)))
+	_ = t.RecordCommand(command, startedAt, endedAt, exitCode)
+}